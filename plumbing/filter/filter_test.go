@@ -0,0 +1,68 @@
+package filter
+
+import "testing"
+
+func TestParseBlobNone(t *testing.T) {
+	s, err := Parse("blob:none")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Kind != BlobNone || !s.Enforceable() {
+		t.Fatalf("got %+v", s)
+	}
+	if s.AllowsBlob(0) {
+		t.Fatalf("blob:none should not allow any blob")
+	}
+}
+
+func TestParseBlobLimit(t *testing.T) {
+	s, err := Parse("blob:limit=1k")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Kind != BlobLimit || s.Limit != 1024 {
+		t.Fatalf("got %+v", s)
+	}
+	if !s.AllowsBlob(1024) || s.AllowsBlob(1025) {
+		t.Fatalf("blob:limit=1k should allow <=1024 bytes only")
+	}
+}
+
+func TestParseTreeAndSparseAreNotEnforceable(t *testing.T) {
+	for _, spec := range []string{"tree:0", "sparse:oid=" + "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"} {
+		s, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", spec, err)
+		}
+		if s.Enforceable() {
+			t.Fatalf("Parse(%q) should not be Enforceable yet", spec)
+		}
+	}
+}
+
+func TestParseCombine(t *testing.T) {
+	s, err := Parse("blob:none+blob:limit=10")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Kind != Combine || len(s.Subs) != 2 {
+		t.Fatalf("got %+v", s)
+	}
+	if !s.Enforceable() {
+		t.Fatalf("a combine of enforceable filters should itself be enforceable")
+	}
+
+	s, err = Parse("blob:limit=10+tree:0")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Enforceable() {
+		t.Fatalf("a combine containing tree: should not be enforceable")
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	if _, err := Parse("object:type=tag"); err == nil {
+		t.Fatalf("expected an error for an unrecognized filter spec")
+	}
+}
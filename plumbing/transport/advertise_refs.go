@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// AdvertiseReferencesFilteredOptions controls which references
+// AdvertiseReferencesFiltered streams and how each one is annotated.
+type AdvertiseReferencesFilteredOptions struct {
+	// Prefixes restricts the advertisement to references whose name has
+	// one of these prefixes. No prefixes advertises every reference.
+	Prefixes []string
+	// Symrefs annotates symbolic references (e.g. HEAD) with their
+	// `symref-target:<ref>` value.
+	Symrefs bool
+	// Peel annotates annotated tags with the `peeled:<oid>` of the
+	// object they point to.
+	Peel bool
+}
+
+// AdvertiseReferencesFiltered streams one pkt-line per reference matching
+// opts, followed by a flush packet. It's the machinery protocol v2's
+// ls-refs command uses (see serveLsRefsV2), factored out so embedders can
+// reuse it without spinning up a full upload-pack session.
+//
+// service names the advertised service, e.g. UploadPackService; pass "" to
+// omit the `#service=<name>` marker line, as ls-refs v2 does.
+func AdvertiseReferencesFiltered(
+	ctx context.Context,
+	st storage.Storer,
+	w io.Writer,
+	service string,
+	opts AdvertiseReferencesFilteredOptions,
+) error {
+	if service != "" {
+		if _, err := pktline.Writef(w, "# service=%s\n", service); err != nil {
+			return err
+		}
+		if err := pktline.WriteFlush(w); err != nil {
+			return err
+		}
+	}
+
+	refs, err := st.IterReferences()
+	if err != nil {
+		return err
+	}
+	defer refs.Close()
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if len(opts.Prefixes) > 0 && !hasAnyPrefix(ref.Name().String(), opts.Prefixes) {
+			return nil
+		}
+
+		_, err := pktline.Writef(w, "%s\n", referenceAdvertisementLine(st, ref, opts))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return pktline.WriteFlush(w)
+}
+
+// referenceAdvertisementLine formats a single "<oid> <name> [attrs...]"
+// advertisement line for ref per opts. For a symbolic reference, ref.Hash
+// is the zero hash, so the advertised oid is resolved through the
+// reference chain instead.
+func referenceAdvertisementLine(st storage.Storer, ref *plumbing.Reference, opts AdvertiseReferencesFilteredOptions) string {
+	hash := ref.Hash()
+	if ref.Type() == plumbing.SymbolicReference {
+		if resolved, err := storer.ResolveReference(st, ref.Name()); err == nil {
+			hash = resolved.Hash()
+		}
+	}
+
+	line := hash.String() + " " + ref.Name().String()
+
+	if opts.Symrefs && ref.Type() == plumbing.SymbolicReference {
+		line += " symref-target:" + ref.Target().String()
+	}
+
+	if opts.Peel {
+		if tag, err := object.GetTag(st, hash); err == nil {
+			line += " peeled:" + tag.Target.String()
+		}
+	}
+
+	return line
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
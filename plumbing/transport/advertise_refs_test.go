@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestAdvertiseReferencesFilteredResolvesSymbolicRef(t *testing.T) {
+	st := memory.NewStorage()
+	target := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/main", target),
+		plumbing.NewSymbolicReference("HEAD", "refs/heads/main"),
+	}
+	for _, ref := range refs {
+		if err := st.SetReference(ref); err != nil {
+			t.Fatalf("SetReference: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	opts := AdvertiseReferencesFilteredOptions{Prefixes: []string{"HEAD"}, Symrefs: true}
+	if err := AdvertiseReferencesFiltered(context.Background(), st, &buf, "", opts); err != nil {
+		t.Fatalf("AdvertiseReferencesFiltered: %v", err)
+	}
+
+	line := buf.String()
+	if !strings.Contains(line, target.String()) {
+		t.Fatalf("want HEAD advertised with its resolved oid %s, got %q", target, line)
+	}
+	if strings.Contains(line, plumbing.ZeroHash.String()) {
+		t.Fatalf("HEAD should not be advertised with the zero hash, got %q", line)
+	}
+	if !strings.Contains(line, "symref-target:refs/heads/main") {
+		t.Fatalf("want symref-target annotation, got %q", line)
+	}
+}
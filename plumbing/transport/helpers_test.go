@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// commitBuilder stores a small, synthetic commit graph in an in-memory
+// storer for tests that only care about commit ancestry and metadata, not
+// trees or blobs.
+type commitBuilder struct {
+	st storage.Storer
+}
+
+func newCommitBuilder() *commitBuilder {
+	return &commitBuilder{st: memory.NewStorage()}
+}
+
+// commit creates and stores a commit with the given commit time and
+// parents, returning its hash.
+func (b *commitBuilder) commit(when time.Time, parents ...plumbing.Hash) plumbing.Hash {
+	c := &object.Commit{
+		Author:       object.Signature{Name: "test", When: when},
+		Committer:    object.Signature{Name: "test", When: when},
+		Message:      "test commit",
+		ParentHashes: parents,
+	}
+
+	obj := b.st.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		panic(err)
+	}
+
+	h, err := b.st.SetEncodedObject(obj)
+	if err != nil {
+		panic(err)
+	}
+
+	return h
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// RefUpdate describes a single ref update as processed by ReceivePack: the
+// ref's old and new object ids and its full name. A zero Old means the ref
+// is being created; a zero New means it is being deleted.
+type RefUpdate struct {
+	Name string
+	Old  plumbing.Hash
+	New  plumbing.Hash
+}
+
+// Hooks lets embedders observe and veto the ref updates ReceivePack
+// carries, mirroring the pre-receive/update/post-receive hooks native git
+// servers run. Each method is given an io.Writer bound to the sideband
+// progress channel, so implementations can stream messages back to the
+// pushing client the way git's hook scripts do by writing to stdout, and a
+// context.Context for cancellation.
+//
+// A nil Hooks is treated as a no-op that never vetoes anything.
+type Hooks interface {
+	// PreReceive is called once per push, with the full list of ref
+	// updates, before any ref is written. Returning an error rejects the
+	// whole push: the error's message is streamed to the client over the
+	// sideband, and every ref is reported back as a `ng` report-status
+	// line.
+	PreReceive(ctx context.Context, w io.Writer, updates []RefUpdate) error
+
+	// Update is called once per ref update, after PreReceive has
+	// accepted the push as a whole. Returning an error vetoes only that
+	// ref - it is reported as `ng <refname> <message>` - while the rest
+	// of the push proceeds.
+	Update(ctx context.Context, w io.Writer, update RefUpdate) error
+
+	// PostReceive runs once, after every accepted ref update has been
+	// written. It returns nothing, so it can't affect what is reported to
+	// the client: by this point the push has already succeeded or
+	// partially succeeded.
+	PostReceive(ctx context.Context, w io.Writer, updates []RefUpdate)
+
+	// PostUpdate, like PostReceive, runs after the refs are written, but
+	// is given only the names of the refs that were actually updated.
+	// It mirrors git's optional post-update hook, traditionally used to
+	// refresh the dumb-HTTP info/refs file.
+	PostUpdate(ctx context.Context, w io.Writer, refs []string)
+}
+
+// NoopHooks implements Hooks with methods that do nothing and never veto.
+// Embed it to implement only the hooks a particular server cares about.
+type NoopHooks struct{}
+
+func (NoopHooks) PreReceive(context.Context, io.Writer, []RefUpdate) error { return nil }
+func (NoopHooks) Update(context.Context, io.Writer, RefUpdate) error       { return nil }
+func (NoopHooks) PostReceive(context.Context, io.Writer, []RefUpdate)      {}
+func (NoopHooks) PostUpdate(context.Context, io.Writer, []string)          {}
+
+// ExecHooks is the default Hooks implementation, provided for parity with
+// canonical git servers: it shells out to the pre-receive, update,
+// post-receive and post-update executables under GitDir/hooks, the same
+// layout and stdin format git itself uses. A missing or non-executable
+// hook file is treated as absent rather than as an error, matching git's
+// own behavior.
+//
+// Embedders that want to enforce policy without spawning a process - e.g.
+// a small git-hosting daemon - should implement Hooks directly instead.
+type ExecHooks struct {
+	// GitDir is the repository's .git directory; hooks are looked up at
+	// GitDir/hooks/<name>.
+	GitDir string
+}
+
+var _ Hooks = ExecHooks{}
+
+func (h ExecHooks) PreReceive(ctx context.Context, w io.Writer, updates []RefUpdate) error {
+	return h.run(ctx, w, "pre-receive", nil, updates)
+}
+
+func (h ExecHooks) Update(ctx context.Context, w io.Writer, update RefUpdate) error {
+	return h.run(ctx, w, "update", []string{update.Name, update.Old.String(), update.New.String()}, nil)
+}
+
+func (h ExecHooks) PostReceive(ctx context.Context, w io.Writer, updates []RefUpdate) {
+	if err := h.run(ctx, w, "post-receive", nil, updates); err != nil {
+		fmt.Fprintf(w, "post-receive hook: %v\n", err)
+	}
+}
+
+func (h ExecHooks) PostUpdate(ctx context.Context, w io.Writer, refs []string) {
+	if err := h.run(ctx, w, "post-update", refs, nil); err != nil {
+		fmt.Fprintf(w, "post-update hook: %v\n", err)
+	}
+}
+
+// run invokes GitDir/hooks/name, if present and executable, with argv on
+// the command line and, when updates is non-nil, one "<old> <new> <ref>"
+// line per update on stdin - exactly as git feeds its own pre-receive and
+// post-receive hooks.
+func (h ExecHooks) run(ctx context.Context, w io.Writer, name string, argv []string, updates []RefUpdate) error {
+	path := filepath.Join(h.GitDir, "hooks", name)
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&0o111 == 0 {
+		// Not installed, or not executable: git silently skips the hook,
+		// so we do too.
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, path, argv...)
+	cmd.Dir = h.GitDir
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	if updates != nil {
+		var stdin bytes.Buffer
+		for _, u := range updates {
+			fmt.Fprintf(&stdin, "%s %s %s\n", u.Old, u.New, u.Name)
+		}
+		cmd.Stdin = &stdin
+	}
+
+	return cmd.Run()
+}
@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// maxInVain bounds how many consecutive haves the negotiator accepts as
+// unrelated to the wants before giving up and reporting ready.
+const maxInVain = 256
+
+// negotiator implements the server side of have/want negotiation. For
+// each have the client offers, it checks whether that commit is reachable
+// from one of the wants by walking backwards from that want lazily,
+// expanding its frontier only as far as needed and memoizing every commit
+// visited along the way - keeping memory proportional to the commits
+// actually walked rather than the whole repository.
+type negotiator struct {
+	st    storage.Storer
+	wants []plumbing.Hash
+
+	// walkers holds one lazy ancestor walk per want, so a have found
+	// common to one want doesn't stop the server from also telling the
+	// client it's an ancestor of another.
+	walkers map[plumbing.Hash]*wantWalker
+
+	// satisfied is the subset of wants that some have has already been
+	// proven to be a common ancestor of.
+	satisfied map[plumbing.Hash]bool
+
+	// common is the subset of visited commits the client has confirmed it
+	// already has, used to answer repeated haves without rewalking.
+	common map[plumbing.Hash]bool
+
+	inVain int
+}
+
+// wantWalker is the lazy backwards depth-first walk rooted at one want.
+type wantWalker struct {
+	// visited is every commit reached so far while expanding frontier; it
+	// answers "is this an ancestor of (or equal to) the want".
+	visited map[plumbing.Hash]bool
+	// frontier holds visited commits whose parents haven't been walked
+	// yet; it's consumed lazily, one have at a time, across calls.
+	frontier []plumbing.Hash
+}
+
+func newNegotiator(st storage.Storer, wants []plumbing.Hash) *negotiator {
+	n := &negotiator{
+		st:        st,
+		wants:     wants,
+		walkers:   make(map[plumbing.Hash]*wantWalker, len(wants)),
+		satisfied: make(map[plumbing.Hash]bool, len(wants)),
+		common:    map[plumbing.Hash]bool{},
+	}
+
+	for _, w := range wants {
+		if _, ok := n.walkers[w]; !ok {
+			n.walkers[w] = &wantWalker{
+				visited:  map[plumbing.Hash]bool{w: true},
+				frontier: []plumbing.Hash{w},
+			}
+		}
+	}
+
+	return n
+}
+
+// Advertise records a have sent by the client and returns whether it is
+// common - i.e. reachable from at least one of the wants.
+func (n *negotiator) Advertise(have plumbing.Hash) bool {
+	common := n.common[have]
+
+	for _, w := range n.wants {
+		if n.reachableFromWant(w, have) {
+			n.satisfied[w] = true
+			common = true
+		}
+	}
+
+	if !common {
+		n.inVain++
+		return false
+	}
+
+	if !n.common[have] {
+		n.markCommon(have)
+	}
+	n.inVain = 0
+	return true
+}
+
+// Ready reports whether negotiation should stop: either every want has a
+// common ancestor, or too many consecutive haves in a row turned out to
+// be unrelated.
+func (n *negotiator) Ready() bool {
+	if len(n.wants) == 0 {
+		return true
+	}
+
+	if n.inVain >= maxInVain {
+		return true
+	}
+
+	for _, w := range n.wants {
+		if !n.satisfied[w] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reachableFromWant reports whether target is an ancestor of, or equal
+// to, want. It expands want's walk frontier only as far as necessary to
+// answer the question, and never re-walks a commit already visited by an
+// earlier call for the same want.
+func (n *negotiator) reachableFromWant(want, target plumbing.Hash) bool {
+	walker := n.walkers[want]
+	if walker.visited[target] {
+		return true
+	}
+
+	for len(walker.frontier) > 0 {
+		h := walker.frontier[len(walker.frontier)-1]
+		walker.frontier = walker.frontier[:len(walker.frontier)-1]
+
+		commit, err := n.commit(h)
+		if err != nil {
+			continue
+		}
+
+		found := false
+		_ = commit.Parents().ForEach(func(p *object.Commit) error {
+			if !walker.visited[p.Hash] {
+				walker.visited[p.Hash] = true
+				walker.frontier = append(walker.frontier, p.Hash)
+				if p.Hash == target {
+					found = true
+				}
+			}
+			return nil
+		})
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markCommon flags have, and its ancestors, as common: a client that
+// claims to have a commit is assumed to have its full ancestry too, so
+// propagating commonality upfront saves re-deciding it have by have.
+func (n *negotiator) markCommon(have plumbing.Hash) {
+	stack := []plumbing.Hash{have}
+
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if n.common[h] {
+			continue
+		}
+		n.common[h] = true
+
+		commit, err := n.commit(h)
+		if err != nil {
+			continue
+		}
+
+		_ = commit.Parents().ForEach(func(p *object.Commit) error {
+			if !n.common[p.Hash] {
+				stack = append(stack, p.Hash)
+			}
+			return nil
+		})
+	}
+}
+
+func (n *negotiator) commit(h plumbing.Hash) (*object.Commit, error) {
+	obj, err := n.st.EncodedObject(plumbing.CommitObject, h)
+	if err != nil {
+		return nil, err
+	}
+	return object.DecodeCommit(n.st, obj)
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestNegotiatorCommonAncestor(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	c1 := b.commit(now.Add(-3 * time.Hour))
+	c2 := b.commit(now.Add(-2*time.Hour), c1)
+	want := b.commit(now.Add(-1*time.Hour), c2)
+
+	n := newNegotiator(b.st, []plumbing.Hash{want})
+
+	if !n.Advertise(c1) {
+		t.Fatalf("c1 is an ancestor of want and should be reported common")
+	}
+	if !n.Advertise(c2) {
+		t.Fatalf("c2 is an ancestor of want and should be reported common")
+	}
+	if !n.Ready() {
+		t.Fatalf("every want has a common ancestor, negotiation should be ready")
+	}
+}
+
+func TestNegotiatorUnrelatedHave(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	want := b.commit(now)
+	unrelated := b.commit(now.Add(-time.Hour))
+
+	n := newNegotiator(b.st, []plumbing.Hash{want})
+
+	if n.Advertise(unrelated) {
+		t.Fatalf("unrelated commit should not be marked common")
+	}
+	if n.Ready() {
+		t.Fatalf("want has no common ancestor yet, negotiation should not be ready")
+	}
+}
@@ -0,0 +1,202 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// ReceivePackOptions is a set of options for the ReceivePack service.
+type ReceivePackOptions struct {
+	GitProtocol   string
+	AdvertiseRefs bool
+	StatelessRPC  bool
+
+	// Hooks runs around the ref updates carried by a push. A nil Hooks
+	// runs no hooks and vetoes nothing.
+	Hooks Hooks
+}
+
+// ReceivePack is a server command that serves the receive-pack service:
+// it unpacks the pushed packfile, runs the update through Hooks, writes
+// the accepted refs, and reports each command's outcome back to the
+// client.
+func ReceivePack(
+	ctx context.Context,
+	st storage.Storer,
+	r io.ReadCloser,
+	w io.WriteCloser,
+	opts *ReceivePackOptions,
+) error {
+	if r == nil || w == nil {
+		return fmt.Errorf("nil reader or writer")
+	}
+
+	if opts == nil {
+		opts = &ReceivePackOptions{}
+	}
+
+	hooks := opts.Hooks
+	if hooks == nil {
+		hooks = NoopHooks{}
+	}
+
+	if opts.AdvertiseRefs || !opts.StatelessRPC {
+		if err := AdvertiseReferences(ctx, st, w, ReceivePackService, opts.StatelessRPC); err != nil {
+			return fmt.Errorf("advertising references: %w", err)
+		}
+
+		if opts.AdvertiseRefs {
+			return nil
+		}
+	}
+
+	rd := bufio.NewReader(r)
+	l, _, err := pktline.PeekLine(rd)
+	if err != nil {
+		return fmt.Errorf("peeking line: %w", err)
+	}
+
+	if l == pktline.Flush {
+		return nil
+	}
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(rd); err != nil {
+		return fmt.Errorf("decoding reference-update-request: %w", err)
+	}
+
+	if err := r.Close(); err != nil {
+		return fmt.Errorf("closing reader: %w", err)
+	}
+
+	var mux *sideband.Muxer
+	progress := io.Discard
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		mux = sideband.NewMuxer(sideband.Sideband64k, w)
+		progress = sidebandChannel{mux, sideband.ProgressMessage}
+	}
+
+	report := receivePack(ctx, st, req, hooks, progress)
+
+	if req.Capabilities.Supports(capability.ReportStatus) {
+		if err := report.Encode(w); err != nil {
+			return fmt.Errorf("sending report-status: %w", err)
+		}
+	}
+
+	return w.Close()
+}
+
+// receivePack unpacks req's packfile, then runs the carried ref updates
+// through hooks in the same order git's own receive-pack does: PreReceive
+// for the whole push, Update per ref, the accepted refs are written, and
+// finally PostReceive and PostUpdate.
+func receivePack(ctx context.Context, st storage.Storer, req *packp.ReferenceUpdateRequest, hooks Hooks, progress io.Writer) *packp.ReportStatus {
+	report := packp.NewReportStatus()
+	report.UnpackStatus = "ok"
+
+	updates := make([]RefUpdate, len(req.Commands))
+	for i, cmd := range req.Commands {
+		updates[i] = RefUpdate{Name: string(cmd.Name), Old: cmd.Old, New: cmd.New}
+	}
+
+	if req.Packfile != nil {
+		if err := packfile.UpdateObjectStorage(st, req.Packfile); err != nil {
+			report.UnpackStatus = err.Error()
+			rejectAll(report, updates, "unpacker error")
+			return report
+		}
+	}
+
+	if err := hooks.PreReceive(ctx, progress, updates); err != nil {
+		fmt.Fprintf(progress, "pre-receive hook declined: %s\n", err)
+		rejectAll(report, updates, err.Error())
+		return report
+	}
+
+	var updatedRefs []string
+	for i, cmd := range req.Commands {
+		if err := hooks.Update(ctx, progress, updates[i]); err != nil {
+			report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{ReferenceName: cmd.Name, Status: err.Error()})
+			continue
+		}
+
+		if err := applyCommand(st, cmd); err != nil {
+			report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{ReferenceName: cmd.Name, Status: err.Error()})
+			continue
+		}
+
+		report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{ReferenceName: cmd.Name, Status: "ok"})
+		updatedRefs = append(updatedRefs, string(cmd.Name))
+	}
+
+	hooks.PostReceive(ctx, progress, updates)
+	hooks.PostUpdate(ctx, progress, updatedRefs)
+
+	return report
+}
+
+// applyCommand writes a single accepted ref update, using cmd.Old as a
+// compare-and-swap guard against a concurrent or stale push: the write is
+// rejected if the ref's stored value no longer matches what the client
+// last saw. New.IsZero deletes the ref, otherwise it's created (Old.IsZero)
+// or moved to New; whether New descends from Old is not checked.
+func applyCommand(st storage.Storer, cmd *packp.Command) error {
+	var old *plumbing.Reference
+	if !cmd.Old.IsZero() {
+		old = plumbing.NewHashReference(cmd.Name, cmd.Old)
+	} else if !cmd.New.IsZero() {
+		if _, err := st.Reference(cmd.Name); err == nil {
+			return fmt.Errorf("stale info: %s already exists", cmd.Name)
+		}
+	}
+
+	if cmd.New.IsZero() {
+		current, err := st.Reference(cmd.Name)
+		if err != nil {
+			return fmt.Errorf("stale info: %s does not exist", cmd.Name)
+		}
+		if old != nil && current.Hash() != old.Hash() {
+			return fmt.Errorf("stale info: %s has changed", cmd.Name)
+		}
+		return st.RemoveReference(cmd.Name)
+	}
+
+	if err := st.CheckAndSetReference(plumbing.NewHashReference(cmd.Name, cmd.New), old); err != nil {
+		return fmt.Errorf("stale info: %w", err)
+	}
+	return nil
+}
+
+// rejectAll reports every update as ng with the same message, mirroring
+// how a failed PreReceive hook rejects the whole push.
+func rejectAll(report *packp.ReportStatus, updates []RefUpdate, msg string) {
+	for _, u := range updates {
+		report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: plumbing.ReferenceName(u.Name),
+			Status:        msg,
+		})
+	}
+}
+
+// sidebandChannel adapts one channel of a sideband muxer to a plain
+// io.Writer, so Hooks can stream messages to the pushing client without
+// depending on the sideband package.
+type sidebandChannel struct {
+	mux *sideband.Muxer
+	ch  sideband.Channel
+}
+
+func (s sidebandChannel) Write(p []byte) (int, error) {
+	return s.mux.WriteChannel(s.ch, p)
+}
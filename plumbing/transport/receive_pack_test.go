@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// fakeHooks is a test double for Hooks: it can reject the whole push from
+// PreReceive, veto a single named ref from Update, and records what
+// PostReceive/PostUpdate were called with.
+type fakeHooks struct {
+	preErr     error
+	vetoUpdate string
+
+	post       []RefUpdate
+	postUpdate []string
+}
+
+func (h *fakeHooks) PreReceive(ctx context.Context, w io.Writer, updates []RefUpdate) error {
+	return h.preErr
+}
+
+func (h *fakeHooks) Update(ctx context.Context, w io.Writer, u RefUpdate) error {
+	if u.Name == h.vetoUpdate {
+		return errors.New("vetoed")
+	}
+	return nil
+}
+
+func (h *fakeHooks) PostReceive(ctx context.Context, w io.Writer, updates []RefUpdate) {
+	h.post = updates
+}
+
+func (h *fakeHooks) PostUpdate(ctx context.Context, w io.Writer, refs []string) {
+	h.postUpdate = refs
+}
+
+func TestReceivePackPreReceiveRejectsWholePush(t *testing.T) {
+	st := memory.NewStorage()
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/main", Old: plumbing.ZeroHash, New: plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
+	}
+
+	h := &fakeHooks{preErr: errors.New("denied")}
+	report := receivePack(context.Background(), st, req, h, io.Discard)
+
+	if len(report.CommandStatuses) != 1 || report.CommandStatuses[0].Status != "denied" {
+		t.Fatalf("want rejected command, got %+v", report.CommandStatuses)
+	}
+	if _, err := st.Reference("refs/heads/main"); err == nil {
+		t.Fatalf("ref should not have been written when PreReceive rejects the push")
+	}
+}
+
+func TestReceivePackUpdateVetoesSingleRef(t *testing.T) {
+	st := memory.NewStorage()
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/main", Old: plumbing.ZeroHash, New: plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
+		{Name: "refs/heads/other", Old: plumbing.ZeroHash, New: plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")},
+	}
+
+	h := &fakeHooks{vetoUpdate: "refs/heads/main"}
+	report := receivePack(context.Background(), st, req, h, io.Discard)
+
+	statuses := map[string]string{}
+	for _, cs := range report.CommandStatuses {
+		statuses[string(cs.ReferenceName)] = cs.Status
+	}
+	if statuses["refs/heads/main"] != "vetoed" {
+		t.Fatalf("want main vetoed, got %q", statuses["refs/heads/main"])
+	}
+	if statuses["refs/heads/other"] != "ok" {
+		t.Fatalf("want other accepted, got %q", statuses["refs/heads/other"])
+	}
+
+	if _, err := st.Reference("refs/heads/main"); err == nil {
+		t.Fatalf("vetoed ref should not have been written")
+	}
+	if ref, err := st.Reference("refs/heads/other"); err != nil || ref.Hash().String() != "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb" {
+		t.Fatalf("accepted ref should have been written, got %v, %v", ref, err)
+	}
+
+	if len(h.post) != 2 {
+		t.Fatalf("PostReceive should see both updates, got %d", len(h.post))
+	}
+	if len(h.postUpdate) != 1 || h.postUpdate[0] != "refs/heads/other" {
+		t.Fatalf("PostUpdate should see only the accepted ref, got %v", h.postUpdate)
+	}
+}
+
+// TestReceivePackRejectsStaleOld guards against a command's Old value
+// being dropped before the write: a command whose Old no longer matches
+// the stored ref (a race with a concurrent push, or a stale client) must
+// be rejected instead of force-writing New.
+func TestReceivePackRejectsStaleOld(t *testing.T) {
+	st := memory.NewStorage()
+
+	current := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	if err := st.SetReference(plumbing.NewHashReference("refs/heads/main", current)); err != nil {
+		t.Fatalf("SetReference: %v", err)
+	}
+
+	staleOld := plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/main", Old: staleOld, New: plumbing.NewHash("cccccccccccccccccccccccccccccccccccccccc")},
+	}
+
+	report := receivePack(context.Background(), st, req, &fakeHooks{}, io.Discard)
+
+	if len(report.CommandStatuses) != 1 || report.CommandStatuses[0].Status == "ok" {
+		t.Fatalf("want the stale update rejected, got %+v", report.CommandStatuses)
+	}
+
+	ref, err := st.Reference("refs/heads/main")
+	if err != nil || ref.Hash() != current {
+		t.Fatalf("ref should be unchanged after a rejected stale update, got %v, %v", ref, err)
+	}
+}
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// shallowCutoff decides, for a commit reached at a given walk depth,
+// whether the walk should stop descending past it and make the commit a
+// shallow boundary.
+type shallowCutoff func(commit *object.Commit, curDepth int) bool
+
+// getShallowCommits walks the history reachable from heads and reports,
+// via upd, which commits should become the client's new shallow boundary
+// (upd.Shallows) and which previously-shallow commits the new criteria
+// reach past (upd.Unshallows).
+//
+// depth selects the deepen criterion: packp.DepthCommits cuts after N
+// commits, packp.DepthSince cuts at commits older than a given time, and
+// packp.DepthReference (deepen-not) cuts at commits reachable from a named
+// ref. shallows is the client's current shallow boundary; when relative is
+// true (deepen-relative) it's also the starting point for the walk, so the
+// requested depth counts from the previous fetch's frontier rather than
+// from the wants.
+func getShallowCommits(st storage.Storer, heads []plumbing.Hash, depth packp.Depth, shallows []plumbing.Hash, relative bool, upd *packp.ShallowUpdate) error {
+	cutoff, err := newShallowCutoff(st, depth)
+	if err != nil {
+		return err
+	}
+
+	alreadyShallow := make(map[plumbing.Hash]bool, len(shallows))
+	for _, h := range shallows {
+		alreadyShallow[h] = true
+	}
+
+	roots := heads
+	startDepth := 0
+	if relative && len(shallows) > 0 {
+		roots = shallows
+		// Pre-existing shallow commits are the old boundary, not new
+		// history: count depth from their parents, so a relative deepen
+		// of N yields N commits past the old boundary rather than
+		// cutting off at the boundary itself.
+		startDepth = -1
+	}
+
+	var i, curDepth int
+	var commit *object.Commit
+	depths := map[*object.Commit]int{}
+	stack := []object.Object{}
+
+	for commit != nil || i < len(roots) || len(stack) > 0 {
+		if commit == nil {
+			if i < len(roots) {
+				obj, err := st.EncodedObject(plumbing.CommitObject, roots[i])
+				i++
+				if err != nil {
+					continue
+				}
+
+				commit, err = object.DecodeCommit(st, obj)
+				if err != nil {
+					commit = nil
+					continue
+				}
+
+				depths[commit] = startDepth
+				curDepth = startDepth
+			} else if len(stack) > 0 {
+				commit = stack[len(stack)-1].(*object.Commit)
+				stack = stack[:len(stack)-1]
+				curDepth = depths[commit]
+			}
+		}
+
+		curDepth++
+
+		if cutoff(commit, curDepth) {
+			upd.Shallows = append(upd.Shallows, commit.Hash)
+			commit = nil
+			continue
+		}
+
+		if alreadyShallow[commit.Hash] {
+			// The client already treats this commit as a shallow boundary,
+			// but the negotiated criteria reach past it now.
+			upd.Unshallows = append(upd.Unshallows, commit.Hash)
+		}
+
+		parents := commit.Parents()
+		commit = nil
+		for {
+			parent, err := parents.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if depths[parent] != 0 && curDepth >= depths[parent] {
+				continue
+			}
+
+			depths[parent] = curDepth
+
+			if _, err := parents.Next(); err == nil {
+				stack = append(stack, parent)
+			} else {
+				commit = parent
+				curDepth = depths[commit]
+			}
+		}
+	}
+
+	return nil
+}
+
+func newShallowCutoff(st storage.Storer, depth packp.Depth) (shallowCutoff, error) {
+	switch d := depth.(type) {
+	case packp.DepthCommits:
+		n := int(d)
+		return func(_ *object.Commit, curDepth int) bool {
+			return n != math.MaxInt && curDepth >= n
+		}, nil
+	case packp.DepthSince:
+		since := time.Time(d)
+		return func(commit *object.Commit, _ int) bool {
+			return commit.Committer.When.Before(since)
+		}, nil
+	case packp.DepthReference:
+		excluded, err := ancestorsOf(st, plumbing.ReferenceName(d))
+		if err != nil {
+			return nil, fmt.Errorf("resolving deepen-not reference %q: %w", string(d), err)
+		}
+		return func(commit *object.Commit, _ int) bool {
+			return excluded[commit.Hash]
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported depth type %T", depth)
+	}
+}
+
+// ancestorsOf returns the set of commits reachable from the given
+// reference, used to implement deepen-not: commits in this set become the
+// shallow boundary rather than being walked further.
+func ancestorsOf(st storage.Storer, name plumbing.ReferenceName) (map[plumbing.Hash]bool, error) {
+	ref, err := storer.ResolveReference(st, name)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := map[plumbing.Hash]bool{}
+	stack := []plumbing.Hash{ref.Hash()}
+
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[h] {
+			continue
+		}
+		visited[h] = true
+
+		obj, err := st.EncodedObject(plumbing.CommitObject, h)
+		if err != nil {
+			continue
+		}
+
+		commit, err := object.DecodeCommit(st, obj)
+		if err != nil {
+			continue
+		}
+
+		err = commit.Parents().ForEach(func(p *object.Commit) error {
+			if !visited[p.Hash] {
+				stack = append(stack, p.Hash)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return visited, nil
+}
@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+)
+
+func TestGetShallowCommitsDepthCommits(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	c1 := b.commit(now.Add(-3 * time.Hour))
+	c2 := b.commit(now.Add(-2*time.Hour), c1)
+	c3 := b.commit(now.Add(-1*time.Hour), c2)
+
+	var upd packp.ShallowUpdate
+	if err := getShallowCommits(b.st, []plumbing.Hash{c3}, packp.DepthCommits(2), nil, false, &upd); err != nil {
+		t.Fatalf("getShallowCommits: %v", err)
+	}
+
+	if len(upd.Shallows) != 1 || upd.Shallows[0] != c2 {
+		t.Fatalf("want shallow boundary [%s], got %v", c2, upd.Shallows)
+	}
+}
+
+func TestGetShallowCommitsDepthSince(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	c1 := b.commit(now.Add(-3 * time.Hour))
+	c2 := b.commit(now.Add(-2*time.Hour), c1)
+	c3 := b.commit(now.Add(-1*time.Hour), c2)
+
+	var upd packp.ShallowUpdate
+	since := packp.DepthSince(now.Add(-90 * time.Minute))
+	if err := getShallowCommits(b.st, []plumbing.Hash{c3}, since, nil, false, &upd); err != nil {
+		t.Fatalf("getShallowCommits: %v", err)
+	}
+
+	if len(upd.Shallows) != 1 || upd.Shallows[0] != c2 {
+		t.Fatalf("want shallow boundary [%s], got %v", c2, upd.Shallows)
+	}
+}
+
+func TestGetShallowCommitsDepthReference(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	c1 := b.commit(now.Add(-3 * time.Hour))
+	c2 := b.commit(now.Add(-2*time.Hour), c1)
+	c3 := b.commit(now.Add(-1*time.Hour), c2)
+
+	if err := b.st.SetReference(plumbing.NewHashReference("refs/heads/excluded", c2)); err != nil {
+		t.Fatalf("SetReference: %v", err)
+	}
+
+	var upd packp.ShallowUpdate
+	depth := packp.DepthReference("refs/heads/excluded")
+	if err := getShallowCommits(b.st, []plumbing.Hash{c3}, depth, nil, false, &upd); err != nil {
+		t.Fatalf("getShallowCommits: %v", err)
+	}
+
+	if len(upd.Shallows) != 1 || upd.Shallows[0] != c2 {
+		t.Fatalf("want shallow boundary [%s], got %v", c2, upd.Shallows)
+	}
+}
+
+// TestGetShallowCommitsDeepenRelative guards against a relative deepen
+// being counted from the pre-existing shallow boundary itself rather than
+// from its parents: a "deepen 1" relative to an existing boundary must
+// fetch exactly one new commit past it, not zero.
+func TestGetShallowCommitsDeepenRelative(t *testing.T) {
+	b := newCommitBuilder()
+	now := time.Now()
+
+	c1 := b.commit(now.Add(-3 * time.Hour))
+	c2 := b.commit(now.Add(-2*time.Hour), c1)
+	c3 := b.commit(now.Add(-1*time.Hour), c2)
+
+	shallows := []plumbing.Hash{c3}
+
+	var upd packp.ShallowUpdate
+	if err := getShallowCommits(b.st, []plumbing.Hash{c3}, packp.DepthCommits(1), shallows, true, &upd); err != nil {
+		t.Fatalf("getShallowCommits: %v", err)
+	}
+
+	if len(upd.Shallows) != 1 || upd.Shallows[0] != c2 {
+		t.Fatalf("want new shallow boundary [%s], got %v", c2, upd.Shallows)
+	}
+	if len(upd.Unshallows) != 1 || upd.Unshallows[0] != c3 {
+		t.Fatalf("want unshallow [%s], got %v", c3, upd.Unshallows)
+	}
+}
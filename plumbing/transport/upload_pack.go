@@ -5,12 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filter"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
@@ -44,7 +43,7 @@ func UploadPack(
 
 	switch version := ProtocolVersion(opts.GitProtocol); version {
 	case protocol.V2:
-		// TODO: support version 2
+		return serveUploadPackV2(ctx, st, r, w, opts)
 	case protocol.V1:
 		if _, err := pktline.Writef(w, "version=%s\n", version.String()); err != nil {
 			return err
@@ -87,16 +86,10 @@ func UploadPack(
 			return fmt.Errorf("closing reader: %w", err)
 		}
 
-		// TODO: support deepen-since, and deepen-not
 		var shupd packp.ShallowUpdate
 		if !upreq.Depth.IsZero() {
-			switch depth := upreq.Depth.(type) {
-			case packp.DepthCommits:
-				if err := getShallowCommits(st, upreq.Wants, int(depth), &shupd); err != nil {
-					return fmt.Errorf("getting shallow commits: %w", err)
-				}
-			default:
-				return fmt.Errorf("unsupported depth type %T", upreq.Depth)
+			if err := getShallowCommits(st, upreq.Wants, upreq.Depth, upreq.Shallows, upreq.DeepenRelative, &shupd); err != nil {
+				return fmt.Errorf("getting shallow commits: %w", err)
 			}
 
 			if err := shupd.Encode(w); err != nil {
@@ -109,11 +102,10 @@ func UploadPack(
 			caps  = upreq.Capabilities
 		)
 
-		// Find common commits/objects
-		havesWithRef, err := revlist.ObjectsWithRef(st, wants, nil)
-		if err != nil {
-			return fmt.Errorf("getting objects with ref: %w", err)
-		}
+		// Negotiate common commits lazily: neg only walks the ancestry it
+		// needs to decide each have, instead of precomputing every
+		// ancestor of every want up front.
+		neg := newNegotiator(st, wants)
 
 		// Encode objects to packfile and write to client
 		multiAck := caps.Supports(capability.MultiACK)
@@ -130,16 +122,10 @@ func UploadPack(
 			haves = append(haves, uphav.Haves...)
 			done = uphav.Done
 
-			common := map[plumbing.Hash]struct{}{}
 			var ack packp.ACK
 			var acks []packp.ACK
 			for _, hu := range uphav.Haves {
-				refs, ok := havesWithRef[hu]
-				if ok {
-					for _, ref := range refs {
-						common[ref] = struct{}{}
-					}
-				}
+				ok := neg.Advertise(hu)
 
 				var status packp.ACKStatus
 				if multiAckDetailed {
@@ -160,6 +146,14 @@ func UploadPack(
 				}
 			}
 
+			if multiAckDetailed && !done && neg.Ready() {
+				// Every want has a common ancestor, or too many
+				// consecutive haves turned out unrelated: tell the
+				// client to stop negotiating and send `done`.
+				ack = packp.ACK{Hash: ack.Hash, Status: packp.ACKReady}
+				acks = append(acks, ack)
+			}
+
 			if len(haves) > 0 {
 				// Encode ACKs to client when we have haves
 				srvrsp := packp.ServerResponse{ACKs: acks}
@@ -198,7 +192,12 @@ func UploadPack(
 			return fmt.Errorf("closing reader: %w", err)
 		}
 
-		objs, err := objectsToUpload(st, wants, haves)
+		spec, err := parseFilter(upreq.Filter)
+		if err != nil {
+			return fmt.Errorf("parsing filter: %w", err)
+		}
+
+		objs, err := objectsToUpload(st, wants, haves, spec)
 		if err != nil {
 			w.Close() //nolint:errcheck
 			return fmt.Errorf("getting objects to upload: %w", err)
@@ -227,76 +226,72 @@ func UploadPack(
 	return nil
 }
 
-func objectsToUpload(st storage.Storer, wants, haves []plumbing.Hash) ([]plumbing.Hash, error) {
-	return revlist.Objects(st, wants, haves)
-}
+func objectsToUpload(st storage.Storer, wants, haves []plumbing.Hash, spec filter.Spec) ([]plumbing.Hash, error) {
+	objs, err := revlist.Objects(st, wants, haves)
+	if err != nil {
+		return nil, err
+	}
 
-func getShallowCommits(st storage.Storer, heads []plumbing.Hash, depth int, upd *packp.ShallowUpdate) error {
-	var i, curDepth int
-	var commit *object.Commit
-	depths := map[*object.Commit]int{}
-	stack := []object.Object{}
-
-	for commit != nil || i < len(heads) || len(stack) > 0 {
-		if commit == nil {
-			if i < len(heads) {
-				obj, err := st.EncodedObject(plumbing.CommitObject, heads[i])
-				i++
-				if err != nil {
-					continue
-				}
+	if spec.IsZero() {
+		return objs, nil
+	}
 
-				commit, err = object.DecodeCommit(st, obj)
-				if err != nil {
-					commit = nil
-					continue
-				}
+	// Objects directly named in wants are always sent, even if the
+	// filter would otherwise omit them: that's how a promisor client
+	// fills in a previously-filtered object, by want-ing its oid
+	// explicitly on a follow-up fetch.
+	wanted := make(map[plumbing.Hash]bool, len(wants))
+	for _, h := range wants {
+		wanted[h] = true
+	}
 
-				depths[commit] = 0
-				curDepth = 0
-			} else if len(stack) > 0 {
-				commit = stack[len(stack)-1].(*object.Commit)
-				stack = stack[:len(stack)-1]
-				curDepth = depths[commit]
-			}
+	filtered := make([]plumbing.Hash, 0, len(objs))
+	for _, h := range objs {
+		if wanted[h] || filterAllows(st, spec, h) {
+			filtered = append(filtered, h)
 		}
+	}
 
-		curDepth++
-
-		if depth != math.MaxInt && curDepth >= depth {
-			upd.Shallows = append(upd.Shallows, commit.Hash)
-			commit = nil
-			continue
-		}
+	return filtered, nil
+}
 
-		upd.Unshallows = append(upd.Unshallows, commit.Hash)
+// filterAllows reports whether h should be included in the packfile under
+// spec, which must be Enforceable: blob:none and blob:limit are decided
+// from the blob alone, everything else passes through unchanged.
+func filterAllows(st storage.Storer, spec filter.Spec, h plumbing.Hash) bool {
+	if spec.Kind != filter.BlobNone && spec.Kind != filter.BlobLimit && spec.Kind != filter.Combine {
+		return true
+	}
 
-		parents := commit.Parents()
-		commit = nil
-		for {
-			parent, err := parents.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
+	obj, err := st.EncodedObject(plumbing.BlobObject, h)
+	if err != nil {
+		// Not a blob (or missing): filters above only constrain blobs.
+		return true
+	}
 
-			if depths[parent] != 0 && curDepth >= depths[parent] {
-				continue
-			}
+	return spec.AllowsBlob(obj.Size())
+}
 
-			depths[parent] = curDepth
+// parseFilter parses a `filter <spec>` argument, rejecting filter kinds
+// this server can't enforce (tree, sparse, and any combined filter built
+// from one of those) instead of accepting them and then silently
+// shipping every object anyway. Enforcing tree/sparse filters is deferred
+// to a future change (see filter.Spec.Enforceable); until then, a client
+// that needs them should not negotiate the filter capability against
+// this server.
+func parseFilter(spec string) (filter.Spec, error) {
+	if spec == "" {
+		return filter.Spec{}, nil
+	}
 
-			if _, err := parents.Next(); err == nil {
-				stack = append(stack, parent)
-			} else {
-				commit = parent
-				curDepth = depths[commit]
-			}
-		}
+	s, err := filter.Parse(spec)
+	if err != nil {
+		return filter.Spec{}, err
+	}
 
+	if !s.Enforceable() {
+		return filter.Spec{}, fmt.Errorf("filter spec %q not yet enforceable (tree/sparse filters are deferred)", spec)
 	}
 
-	return nil
+	return s, nil
 }
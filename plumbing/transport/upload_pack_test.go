@@ -0,0 +1,20 @@
+package transport
+
+import "testing"
+
+func TestParseFilterRejectsUnenforceableKinds(t *testing.T) {
+	if _, err := parseFilter("tree:0"); err == nil {
+		t.Fatalf("expected tree: filters to be rejected until tree-depth enforcement exists")
+	}
+	if _, err := parseFilter("blob:limit=10+tree:0"); err == nil {
+		t.Fatalf("expected a combine containing tree: to be rejected")
+	}
+
+	spec, err := parseFilter("blob:none")
+	if err != nil {
+		t.Fatalf("parseFilter(blob:none): %v", err)
+	}
+	if spec.IsZero() {
+		t.Fatalf("blob:none should not parse to the zero filter")
+	}
+}
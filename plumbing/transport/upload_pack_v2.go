@@ -0,0 +1,342 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filter"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// v2Capabilities is the capability advertisement sent in response to a
+// version 2 request. Unlike v0/v1, no references are advertised up front:
+// the client asks for the refs it cares about with an explicit ls-refs
+// command. The fetch line below lists its supported sub-features; the
+// ls-refs line lists none.
+var v2Capabilities = []string{
+	"agent=" + capability.DefaultAgent,
+	"ls-refs",
+	"fetch=shallow filter",
+	"server-option",
+	"object-format=sha1",
+}
+
+// fetchV2Request is the parsed set of arguments sent with a `command=fetch`
+// request under protocol v2.
+type fetchV2Request struct {
+	Wants          []plumbing.Hash
+	Haves          []plumbing.Hash
+	Shallows       []plumbing.Hash
+	Done           bool
+	ThinPack       bool
+	NoProgress     bool
+	Depth          packp.Depth
+	DeepenRelative bool
+	Filter         filter.Spec
+}
+
+// serveUploadPackV2 implements the upload-pack service for Git wire
+// protocol version 2 (see Documentation/technical/protocol-v2.txt in
+// git.git). Protocol v2 turns upload-pack into a small command loop: the
+// server first advertises version=2 plus its capabilities, then repeatedly
+// reads a `command=<name>` request and dispatches it, until the client has
+// nothing left to send. Each command is self-contained, which is what lets
+// v2 work cleanly under StatelessRPC: a single command is handled per
+// invocation, with all state (haves, shallow boundary, ...) supplied afresh
+// by the client on every round-trip.
+func serveUploadPackV2(
+	ctx context.Context,
+	st storage.Storer,
+	r io.ReadCloser,
+	w io.WriteCloser,
+	opts *UploadPackOptions,
+) error {
+	if opts.AdvertiseRefs || !opts.StatelessRPC {
+		if err := writeV2CapabilityAdvertisement(w); err != nil {
+			return fmt.Errorf("advertising v2 capabilities: %w", err)
+		}
+
+		if opts.AdvertiseRefs {
+			return nil
+		}
+	}
+
+	rd := bufio.NewReader(r)
+	for {
+		command, args, err := readV2Command(rd)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading command: %w", err)
+		}
+
+		switch command {
+		case "ls-refs":
+			if err := serveLsRefsV2(ctx, st, w, args); err != nil {
+				return fmt.Errorf("ls-refs: %w", err)
+			}
+		case "fetch":
+			if err := serveFetchV2(st, w, args); err != nil {
+				return fmt.Errorf("fetch: %w", err)
+			}
+		default:
+			return fmt.Errorf("unsupported command %q", command)
+		}
+
+		if opts.StatelessRPC {
+			return r.Close()
+		}
+	}
+}
+
+func writeV2CapabilityAdvertisement(w io.Writer) error {
+	if _, err := pktline.Writef(w, "version=2\n"); err != nil {
+		return err
+	}
+
+	for _, c := range v2Capabilities {
+		if _, err := pktline.Writef(w, "%s\n", c); err != nil {
+			return err
+		}
+	}
+
+	return pktline.WriteFlush(w)
+}
+
+// readV2Command reads a `command=<name>` request: the command pkt-line
+// itself, followed by zero or more argument lines, terminated by a flush
+// packet. It returns io.EOF once the client has nothing left to send,
+// which under StatelessRPC happens immediately after one command.
+func readV2Command(rd *bufio.Reader) (string, []string, error) {
+	l, _, err := pktline.PeekLine(rd)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if l == pktline.Flush {
+		return "", nil, io.EOF
+	}
+
+	s := pktline.NewScanner(rd)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, io.EOF
+	}
+
+	line := strings.TrimSuffix(string(s.Bytes()), "\n")
+	name, ok := strings.CutPrefix(line, "command=")
+	if !ok {
+		return "", nil, fmt.Errorf("expected command=, got %q", line)
+	}
+
+	var args []string
+	for s.Scan() {
+		b := s.Bytes()
+		if len(b) == 0 {
+			// Flush packet: end of the argument list.
+			break
+		}
+		args = append(args, strings.TrimSuffix(string(b), "\n"))
+	}
+	if err := s.Err(); err != nil {
+		return "", nil, err
+	}
+
+	return name, args, nil
+}
+
+// serveLsRefsV2 handles the ls-refs command. It parses the command's own
+// symrefs/peel/ref-prefix arguments into an AdvertiseReferencesFiltered
+// options value and delegates to it, since ls-refs is exactly that
+// advertisement with no `#service=` marker.
+func serveLsRefsV2(ctx context.Context, st storage.Storer, w io.Writer, args []string) error {
+	var opts AdvertiseReferencesFilteredOptions
+
+	for _, a := range args {
+		switch {
+		case a == "symrefs":
+			opts.Symrefs = true
+		case a == "peel":
+			opts.Peel = true
+		case strings.HasPrefix(a, "ref-prefix "):
+			opts.Prefixes = append(opts.Prefixes, strings.TrimPrefix(a, "ref-prefix "))
+		}
+	}
+
+	return AdvertiseReferencesFiltered(ctx, st, w, "", opts)
+}
+
+// serveFetchV2 handles the fetch command: it parses the client's wants,
+// haves and options, negotiates common ancestors exactly like the v0/v1
+// path, and streams back an acknowledgments section followed by a
+// packfile section.
+//
+// A stateless-RPC transport gets exactly one request/response round-trip
+// per invocation, so when the client hasn't sent `done` yet and negotiation
+// isn't ready, the handler simply reports what's common so far and
+// returns; once it is ready, the packfile follows without `done`. The
+// client is expected
+// to reconnect with a further fetch command carrying additional haves.
+func serveFetchV2(st storage.Storer, w io.Writer, args []string) error {
+	req, err := parseFetchV2Args(args)
+	if err != nil {
+		return fmt.Errorf("parsing fetch arguments: %w", err)
+	}
+
+	if req.Depth != nil && !req.Depth.IsZero() {
+		var shupd packp.ShallowUpdate
+		if err := getShallowCommits(st, req.Wants, req.Depth, req.Shallows, req.DeepenRelative, &shupd); err != nil {
+			return fmt.Errorf("getting shallow commits: %w", err)
+		}
+
+		if _, err := pktline.Writef(w, "shallow-info\n"); err != nil {
+			return err
+		}
+		if err := shupd.Encode(w); err != nil {
+			return fmt.Errorf("sending shallow-info: %w", err)
+		}
+		if err := pktline.WriteFlush(w); err != nil {
+			return err
+		}
+	}
+
+	neg := newNegotiator(st, req.Wants)
+
+	var acked []plumbing.Hash
+	for _, h := range req.Haves {
+		if neg.Advertise(h) {
+			acked = append(acked, h)
+		}
+	}
+
+	ready := neg.Ready()
+
+	if _, err := pktline.Writef(w, "acknowledgments\n"); err != nil {
+		return err
+	}
+	for _, a := range acked {
+		if _, err := pktline.Writef(w, "ACK %s\n", a.String()); err != nil {
+			return err
+		}
+	}
+	if len(acked) == 0 {
+		if _, err := pktline.Writef(w, "NAK\n"); err != nil {
+			return err
+		}
+	}
+	if ready {
+		if _, err := pktline.Writef(w, "ready\n"); err != nil {
+			return err
+		}
+	}
+	if err := pktline.WriteFlush(w); err != nil {
+		return err
+	}
+
+	if !req.Done && !ready {
+		return nil
+	}
+
+	objs, err := objectsToUpload(st, req.Wants, req.Haves, req.Filter)
+	if err != nil {
+		return fmt.Errorf("getting objects to upload: %w", err)
+	}
+
+	if _, err := pktline.Writef(w, "packfile\n"); err != nil {
+		return fmt.Errorf("writing packfile section header: %w", err)
+	}
+
+	var writer io.Writer = w
+	if !req.NoProgress {
+		writer = sideband.NewMuxer(sideband.Sideband64k, w)
+	}
+
+	e := packfile.NewEncoder(writer, st, req.ThinPack)
+	if _, err := e.Encode(objs, 10); err != nil {
+		return fmt.Errorf("encoding packfile: %w", err)
+	}
+
+	return pktline.WriteFlush(w)
+}
+
+func parseFetchV2Args(args []string) (*fetchV2Request, error) {
+	req := &fetchV2Request{}
+
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "want "):
+			req.Wants = append(req.Wants, plumbing.NewHash(strings.TrimPrefix(a, "want ")))
+		case strings.HasPrefix(a, "have "):
+			req.Haves = append(req.Haves, plumbing.NewHash(strings.TrimPrefix(a, "have ")))
+		case a == "done":
+			req.Done = true
+		case a == "thin-pack":
+			req.ThinPack = true
+		case a == "no-progress":
+			req.NoProgress = true
+		case a == "ofs-delta":
+			// Nothing to do: the encoder in serveFetchV2 writes ofs-deltas
+			// unless thin-pack was requested, which makes it use ref-deltas.
+		case strings.HasPrefix(a, "deepen "):
+			depth, err := parseUint(strings.TrimPrefix(a, "deepen "))
+			if err != nil {
+				return nil, fmt.Errorf("invalid deepen value: %w", err)
+			}
+			req.Depth = packp.DepthCommits(depth)
+		case strings.HasPrefix(a, "deepen-since "):
+			secs, err := parseUint(strings.TrimPrefix(a, "deepen-since "))
+			if err != nil {
+				return nil, fmt.Errorf("invalid deepen-since value: %w", err)
+			}
+			req.Depth = packp.DepthSince(time.Unix(int64(secs), 0))
+		case strings.HasPrefix(a, "deepen-not "):
+			req.Depth = packp.DepthReference(strings.TrimPrefix(a, "deepen-not "))
+		case a == "deepen-relative":
+			req.DeepenRelative = true
+		case strings.HasPrefix(a, "shallow "):
+			req.Shallows = append(req.Shallows, plumbing.NewHash(strings.TrimPrefix(a, "shallow ")))
+		case strings.HasPrefix(a, "filter "):
+			spec, err := filter.Parse(strings.TrimPrefix(a, "filter "))
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter: %w", err)
+			}
+			if !spec.Enforceable() {
+				return nil, fmt.Errorf("filter spec %q not yet enforceable (tree/sparse filters are deferred)", strings.TrimPrefix(a, "filter "))
+			}
+			req.Filter = spec
+		case a == "include-tag":
+			// TODO: annotate the packfile section with peeled tags.
+		case strings.HasPrefix(a, "want-ref "):
+			// ref-in-want is deferred: v2Capabilities doesn't advertise
+			// it, so a conforming client won't send this, but reject it
+			// explicitly rather than silently ignoring a ref the client
+			// expects to be resolved and included.
+			return nil, fmt.Errorf("ref-in-want is not supported: %q", a)
+		case strings.HasPrefix(a, "packfile-uris "):
+			// TODO: support offloading blobs to packfile URIs.
+		}
+	}
+
+	return req, nil
+}
+
+func parseUint(s string) (int, error) {
+	var n int
+	if _, err := fmt.Sscanf(s, "%d", &n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestParseFetchV2Args(t *testing.T) {
+	want := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	have := plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	req, err := parseFetchV2Args([]string{
+		"want " + want.String(),
+		"have " + have.String(),
+		"thin-pack",
+		"no-progress",
+		"include-tag",
+		"done",
+	})
+	if err != nil {
+		t.Fatalf("parseFetchV2Args: %v", err)
+	}
+
+	if len(req.Wants) != 1 || req.Wants[0] != want {
+		t.Fatalf("want %v, got %v", want, req.Wants)
+	}
+	if len(req.Haves) != 1 || req.Haves[0] != have {
+		t.Fatalf("have %v, got %v", have, req.Haves)
+	}
+	if !req.ThinPack || !req.NoProgress || !req.Done {
+		t.Fatalf("expected thin-pack, no-progress and done to be set: %+v", req)
+	}
+}
+
+func TestParseFetchV2ArgsRejectsWantRef(t *testing.T) {
+	if _, err := parseFetchV2Args([]string{"want-ref refs/heads/main"}); err == nil {
+		t.Fatal("want-ref should be rejected, since ref-in-want isn't advertised or implemented")
+	}
+}
+
+func TestServeLsRefsV2(t *testing.T) {
+	st := memory.NewStorage()
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")),
+		plumbing.NewHashReference("refs/tags/v1", plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")),
+	}
+	for _, ref := range refs {
+		if err := st.SetReference(ref); err != nil {
+			t.Fatalf("SetReference: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := serveLsRefsV2(context.Background(), st, &buf, []string{"ref-prefix refs/heads/"}); err != nil {
+		t.Fatalf("serveLsRefsV2: %v", err)
+	}
+
+	s := pktline.NewScanner(&buf)
+	var lines []string
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			break
+		}
+		lines = append(lines, string(s.Bytes()))
+	}
+
+	if len(lines) != 1 {
+		t.Fatalf("want 1 advertised ref, got %d: %v", len(lines), lines)
+	}
+}